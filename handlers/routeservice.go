@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"errors"
+	"io"
 	"net/http"
+	"strings"
 
 	"golang.org/x/net/context"
 
 	"code.cloudfoundry.org/gorouter/logger"
+	"code.cloudfoundry.org/gorouter/proxy/utils"
+	"code.cloudfoundry.org/gorouter/proxy/utils/metrics"
 	"code.cloudfoundry.org/gorouter/registry"
 	"code.cloudfoundry.org/gorouter/routeservice"
 	"github.com/uber-go/zap"
@@ -19,12 +23,36 @@ type routeService struct {
 	routeRegistry  registry.Registry
 	rsReverseProxy RouteServiceProxy
 	config         *routeservice.RouteServiceConfig
+	metricsSender  metrics.Sender
 	logger         logger.Logger
 }
 
 //go:generate counterfeiter -o fakes/fake_route_service_proxy.go . RouteServiceProxy
 type RouteServiceProxy interface {
-	ServeHTTP(responseWriter http.ResponseWriter, request *http.Request)
+	// ProxyHTTP forwards a regular (or to-be-upgraded) HTTP request to the
+	// route service over net/http.
+	ProxyHTTP(w utils.ProxyResponseWriter, req *http.Request, isWebsocket bool) error
+	// ProxyTCP bridges an already-hijacked connection to the route service
+	// as a raw, bidirectional byte stream, for requests that have left
+	// net/http behind (a websocket upgrade or an HTTP CONNECT tunnel).
+	ProxyTCP(ctx context.Context, rwa ReadWriteAcker, req *TCPRequest) error
+}
+
+// ReadWriteAcker is a hijacked connection bridged to a route service. Before
+// any tunneled bytes are read or written, AckConnection must be called to
+// write the handshake response line -- "101 Switching Protocols" for a
+// websocket upgrade, or "200 Connection Established" for a CONNECT tunnel.
+type ReadWriteAcker interface {
+	io.ReadWriter
+	AckConnection() error
+}
+
+// TCPRequest carries the information ProxyTCP needs to bridge a tunneled,
+// non-HTTP connection to a route service.
+type TCPRequest struct {
+	// Request is the original HTTP request that initiated the tunnel, a
+	// websocket upgrade or a CONNECT.
+	Request *http.Request
 }
 
 // TODO: the new routeService reverseProxy will get injected into this constructor along with the registery
@@ -33,16 +61,39 @@ type RouteServiceProxy interface {
 // If using an internal route service, must change context to have the pool for the route service
 
 // NewRouteService creates a handler responsible for handling route services
-func NewRouteService(routeRegistry registry.Registry, rsReverseProxy RouteServiceProxy, config *routeservice.RouteServiceConfig, logger logger.Logger) negroni.Handler {
+func NewRouteService(routeRegistry registry.Registry, rsReverseProxy RouteServiceProxy, config *routeservice.RouteServiceConfig, metricsSender metrics.Sender, logger logger.Logger) negroni.Handler {
+	if metricsSender == nil {
+		metricsSender = metrics.NullSender{}
+	}
+
 	return &routeService{
 		routeRegistry:  routeRegistry,
 		rsReverseProxy: rsReverseProxy,
 		config:         config,
+		metricsSender:  metricsSender,
 		logger:         logger,
 	}
 }
 
 func (r *routeService) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	if r.config.IsLBProbe(req) {
+		r.metricsSender.SendCounter("lb_probe", 1)
+
+		ctx := context.WithValue(req.Context(), LBProbeCtxKey, true)
+		if !r.config.LogLBProbes() {
+			ctx = context.WithValue(ctx, SkipAccessLogCtxKey, true)
+		}
+		// We're short-circuiting rather than calling next(rw, req), so
+		// rebinding the local req wouldn't be seen by the caller's copy of
+		// the pointer. Overwrite the request in place so the access log
+		// handler wrapping us observes these context values once we return.
+		*req = *req.WithContext(ctx)
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(r.config.LBProbeResponseBody()))
+		return
+	}
+
 	alr := req.Context().Value("AccessLogRecord")
 
 	rp := req.Context().Value("RoutePool")
@@ -52,8 +103,20 @@ func (r *routeService) ServeHTTP(rw http.ResponseWriter, req *http.Request, next
 		return
 	}
 	routePool := rp.(*route.Pool)
+	tagProxyResponseWriter(req, func(prw utils.ProxyResponseWriter) {
+		prw.SetRoutePoolName(routePool.Name())
+	})
+
+	rsMatch := routePool.MatchRouteService(req.URL.Path)
+
+	var routeServiceUrl string
+	var rewriteHost bool
+	if rsMatch != nil {
+		routeServiceUrl = rsMatch.URL
+		rewriteHost = rsMatch.RewriteHost
+		req = req.WithContext(context.WithValue(req.Context(), RouteServiceLocationCtxKey, rsMatch.Location))
+	}
 
-	routeServiceUrl := routePool.RouteServiceUrl()
 	// Attempted to use a route service when it is not supported
 	if routeServiceUrl != "" && !r.config.RouteServiceEnabled() {
 		r.logger.Info("route-service-unsupported")
@@ -102,6 +165,11 @@ func (r *routeService) ServeHTTP(rw http.ResponseWriter, req *http.Request, next
 			req.Header.Del(routeservice.RouteServiceSignature)
 			req.Header.Del(routeservice.RouteServiceMetadata)
 			req.Header.Del(routeservice.RouteServiceForwardedURL)
+
+			req = req.WithContext(context.WithValue(req.Context(), RouteServiceHitCtxKey, true))
+			tagProxyResponseWriter(req, func(prw utils.ProxyResponseWriter) {
+				prw.SetRouteServiceHit(true)
+			})
 		} else {
 			var err error
 			// should not hardcode http, will be addressed by #100982038
@@ -123,6 +191,17 @@ func (r *routeService) ServeHTTP(rw http.ResponseWriter, req *http.Request, next
 			req.Header.Set(routeservice.RouteServiceForwardedURL, routeServiceArgs.ForwardedURL)
 
 			req = req.WithContext(context.WithValue(req.Context(), RouteServiceURLCtxKey, routeServiceArgs.ParsedUrl))
+			req = req.WithContext(context.WithValue(req.Context(), RouteServiceHitCtxKey, true))
+			tagProxyResponseWriter(req, func(prw utils.ProxyResponseWriter) {
+				prw.SetRouteServiceHit(true)
+			})
+
+			if rewriteHost {
+				// The route service origin may not recognize the original
+				// Host, e.g. a platform that vhost-routes on it; rewrite it
+				// to match the route service so the request isn't 404'd.
+				req.Host = routeServiceArgs.ParsedUrl.Host
+			}
 
 			routeURI := route.Uri(routeServiceArgs.ParsedUrl.Host + routeServiceArgs.ParsedUrl.Path)
 			rsPool := r.routeRegistry.Lookup(routeURI)
@@ -130,7 +209,16 @@ func (r *routeService) ServeHTTP(rw http.ResponseWriter, req *http.Request, next
 			req = req.WithContext(context.WithValue(req.Context(), "RoutePool", rsPool))
 
 			if rsPool == nil || rsPool.IsEmpty() {
-				r.rsReverseProxy.ServeHTTP(rw, req)
+				if err := r.proxyToRouteService(rw, req); err != nil {
+					r.logger.Error("route-service-proxy-failed", zap.Error(err))
+					writeStatus(
+						rw,
+						http.StatusBadGateway,
+						"Route service proxy failed.",
+						alr,
+						r.logger,
+					)
+				}
 				return
 			}
 		}
@@ -141,3 +229,51 @@ func (r *routeService) ServeHTTP(rw http.ResponseWriter, req *http.Request, next
 func hasBeenToRouteService(rsUrl, sigHeader string) bool {
 	return sigHeader != "" && rsUrl != ""
 }
+
+// proxyToRouteService dispatches the request to the configured
+// RouteServiceProxy, picking ProxyTCP for requests that want to leave
+// net/http behind (a websocket upgrade or a CONNECT tunnel) and ProxyHTTP
+// otherwise.
+func (r *routeService) proxyToRouteService(rw http.ResponseWriter, req *http.Request) error {
+	isWebsocket := isWebsocketUpgrade(req)
+
+	if req.Method == http.MethodConnect || isWebsocket {
+		ackLine := "HTTP/1.1 200 Connection Established\r\n\r\n"
+		if isWebsocket {
+			ackLine = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+		}
+
+		rwa, err := utils.NewReadWriteAcker(proxyResponseWriterFromContext(req, rw), ackLine)
+		if err != nil {
+			return err
+		}
+		return r.rsReverseProxy.ProxyTCP(req.Context(), rwa, &TCPRequest{Request: req})
+	}
+
+	return r.rsReverseProxy.ProxyHTTP(proxyResponseWriterFromContext(req, rw), req, isWebsocket)
+}
+
+// isWebsocketUpgrade reports whether req is asking to be upgraded to the
+// websocket protocol.
+func isWebsocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// tagProxyResponseWriter applies fn to the ProxyResponseWriter the proxy
+// front door stashed on req's context, if any. It is a no-op when none is
+// present, e.g. in tests that exercise ServeHTTP directly.
+func tagProxyResponseWriter(req *http.Request, fn func(utils.ProxyResponseWriter)) {
+	if prw, ok := req.Context().Value(ProxyResponseWriterCtxKey).(utils.ProxyResponseWriter); ok {
+		fn(prw)
+	}
+}
+
+// proxyResponseWriterFromContext returns the ProxyResponseWriter that the
+// proxy front door stashed on the request context, falling back to wrapping
+// rw directly if none is present (e.g. in tests).
+func proxyResponseWriterFromContext(req *http.Request, rw http.ResponseWriter) utils.ProxyResponseWriter {
+	if prw, ok := req.Context().Value(ProxyResponseWriterCtxKey).(utils.ProxyResponseWriter); ok {
+		return prw
+	}
+	return utils.NewProxyResponseWriter(rw, metrics.NullSender{}, metrics.Tags{})
+}
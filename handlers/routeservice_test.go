@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"code.cloudfoundry.org/gorouter/proxy/utils"
+	"code.cloudfoundry.org/gorouter/proxy/utils/metrics"
+	"code.cloudfoundry.org/gorouter/registry"
+	"code.cloudfoundry.org/gorouter/route"
+	"code.cloudfoundry.org/gorouter/routeservice"
+	"github.com/uber-go/zap"
+)
+
+type fakeLogger struct{}
+
+func (fakeLogger) Debug(msg string, fields ...zap.Field) {}
+func (fakeLogger) Info(msg string, fields ...zap.Field)  {}
+func (fakeLogger) Error(msg string, fields ...zap.Field) {}
+
+// fakeRegistry is a registry.Registry that records whether it was consulted,
+// so tests can assert a short-circuited request never reaches it.
+type fakeRegistry struct {
+	lookupResult *route.Pool
+	lookupCalls  int
+}
+
+func (f *fakeRegistry) Lookup(uri route.Uri) *route.Pool {
+	f.lookupCalls++
+	return f.lookupResult
+}
+func (f *fakeRegistry) RegisterRouteService(host route.Uri, location string, rsURL string, rewriteHost bool) {
+}
+func (f *fakeRegistry) UnregisterRouteService(host route.Uri, location string) {}
+
+var _ registry.Registry = (*fakeRegistry)(nil)
+
+// fakeRouteServiceProxy is a RouteServiceProxy that records which of
+// ProxyHTTP/ProxyTCP it was dispatched to, and the request it was handed.
+type fakeRouteServiceProxy struct {
+	httpCalled bool
+	tcpCalled  bool
+	lastReq    *http.Request
+}
+
+func (f *fakeRouteServiceProxy) ProxyHTTP(w utils.ProxyResponseWriter, req *http.Request, isWebsocket bool) error {
+	f.httpCalled = true
+	f.lastReq = req
+	return nil
+}
+
+func (f *fakeRouteServiceProxy) ProxyTCP(ctx context.Context, rwa ReadWriteAcker, req *TCPRequest) error {
+	f.tcpCalled = true
+	f.lastReq = req.Request
+	return nil
+}
+
+// fakeHijackableResponseWriter is a minimal http.ResponseWriter that can be
+// hijacked to a net.Pipe conn, standing in for a client connection.
+type fakeHijackableResponseWriter struct {
+	header http.Header
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+}
+
+func (f *fakeHijackableResponseWriter) Header() http.Header {
+	if f.header == nil {
+		f.header = make(http.Header)
+	}
+	return f.header
+}
+func (f *fakeHijackableResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (f *fakeHijackableResponseWriter) WriteHeader(int)             {}
+func (f *fakeHijackableResponseWriter) Flush()                      {}
+func (f *fakeHijackableResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return f.conn, f.rw, nil
+}
+
+func newRouteServiceForTest(reg registry.Registry, proxy RouteServiceProxy, config *routeservice.RouteServiceConfig) *routeService {
+	handler := NewRouteService(reg, proxy, config, metrics.NullSender{}, fakeLogger{})
+	return handler.(*routeService)
+}
+
+func TestServeHTTPShortCircuitsLBProbe(t *testing.T) {
+	config := &routeservice.RouteServiceConfig{LBProbePath: "/healthz"}
+	reg := &fakeRegistry{}
+	proxy := &fakeRouteServiceProxy{}
+	rs := newRouteServiceForTest(reg, proxy, config)
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rw := httptest.NewRecorder()
+
+	nextCalled := false
+	rs.ServeHTTP(rw, req, func(http.ResponseWriter, *http.Request) { nextCalled = true })
+
+	if nextCalled {
+		t.Fatal("expected an LB probe to short-circuit rather than call next")
+	}
+	if reg.lookupCalls != 0 {
+		t.Fatalf("expected an LB probe to skip registry lookups entirely, got %d", reg.lookupCalls)
+	}
+	if proxy.httpCalled || proxy.tcpCalled {
+		t.Fatal("expected an LB probe to skip route service proxying entirely")
+	}
+	if rw.Code != http.StatusOK || rw.Body.String() != "ok" {
+		t.Fatalf("expected 200 \"ok\", got %d %q", rw.Code, rw.Body.String())
+	}
+
+	// ServeHTTP short-circuits rather than calling next(rw, req), so it must
+	// mutate req in place for a wrapping handler (e.g. the access logger)
+	// holding the same *http.Request to observe the decision after return.
+	if hit, _ := req.Context().Value(LBProbeCtxKey).(bool); !hit {
+		t.Fatal("expected LBProbeCtxKey to be visible on req after ServeHTTP returns")
+	}
+}
+
+func TestServeHTTPRewritesHostBeforeForwardingToRouteService(t *testing.T) {
+	config, err := routeservice.NewRouteServiceConfig(true, false, []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewRouteServiceConfig: %v", err)
+	}
+
+	routePool := route.NewPool("myapp.example.com")
+	routePool.RegisterRouteService("", "https://rs.example.com", true)
+
+	reg := &fakeRegistry{lookupResult: nil}
+	proxy := &fakeRouteServiceProxy{}
+	rs := newRouteServiceForTest(reg, proxy, config)
+
+	req := httptest.NewRequest(http.MethodGet, "http://myapp.example.com/foo", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "RoutePool", routePool))
+	rw := httptest.NewRecorder()
+
+	nextCalled := false
+	rs.ServeHTTP(rw, req, func(http.ResponseWriter, *http.Request) { nextCalled = true })
+
+	if nextCalled {
+		t.Fatal("expected the route service forward to short-circuit next")
+	}
+	if !proxy.httpCalled {
+		t.Fatal("expected ProxyHTTP to be called")
+	}
+	if proxy.lastReq == nil || proxy.lastReq.Host != "rs.example.com" {
+		t.Fatalf("expected the Host header to be rewritten to the route service's host, got %+v", proxy.lastReq)
+	}
+}
+
+func TestServeHTTPDispatchesConnectToProxyTCP(t *testing.T) {
+	config, err := routeservice.NewRouteServiceConfig(true, false, []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewRouteServiceConfig: %v", err)
+	}
+
+	routePool := route.NewPool("myapp.example.com")
+	routePool.RegisterRouteService("", "https://rs.example.com", false)
+
+	reg := &fakeRegistry{lookupResult: nil}
+	proxy := &fakeRouteServiceProxy{}
+	rs := newRouteServiceForTest(reg, proxy, config)
+
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	rw := &fakeHijackableResponseWriter{
+		conn: serverSide,
+		rw:   bufio.NewReadWriter(bufio.NewReader(serverSide), bufio.NewWriter(serverSide)),
+	}
+
+	req := httptest.NewRequest(http.MethodConnect, "http://myapp.example.com/foo", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "RoutePool", routePool))
+
+	done := make(chan struct{})
+	go func() {
+		rs.ServeHTTP(rw, req, func(http.ResponseWriter, *http.Request) {})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeHTTP did not return")
+	}
+
+	if !proxy.tcpCalled {
+		t.Fatal("expected a CONNECT request to be dispatched to ProxyTCP")
+	}
+	if proxy.httpCalled {
+		t.Fatal("expected a CONNECT request not to be dispatched to ProxyHTTP")
+	}
+}
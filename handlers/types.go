@@ -13,3 +13,23 @@ const RouteServiceURLCtxKey key = "RouteServiceURL"
 // InternalRouteServiceCtxKey is a key used to mark requests
 // to indicate the route service is an app running on CF
 const InternalRouteServiceCtxKey key = "InternalRouteService"
+
+// RouteServiceHitCtxKey is a key used to mark requests that were forwarded
+// through a route service, as opposed to going straight to a backend, for
+// downstream handlers such as the access logger.
+const RouteServiceHitCtxKey key = "RouteServiceHit"
+
+// LBProbeCtxKey is a key used to mark requests that were short-circuited
+// because they matched a configured load balancer probe signature, so
+// downstream handlers can observe the decision.
+const LBProbeCtxKey key = "LBProbe"
+
+// SkipAccessLogCtxKey is a key used to mark requests that should not emit
+// an access log entry, e.g. a load balancer probe that was not explicitly
+// configured to be logged.
+const SkipAccessLogCtxKey key = "SkipAccessLog"
+
+// RouteServiceLocationCtxKey is a key used to record which of a route
+// pool's possibly several path-prefixed route service registrations
+// matched the request, for downstream handlers and logging.
+const RouteServiceLocationCtxKey key = "RouteServiceLocation"
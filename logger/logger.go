@@ -0,0 +1,11 @@
+package logger
+
+import "github.com/uber-go/zap"
+
+// Logger is the structured logging interface gorouter's handlers log
+// through, so call sites don't depend on a particular logging backend.
+type Logger interface {
+	Debug(msg string, fields ...zap.Field)
+	Info(msg string, fields ...zap.Field)
+	Error(msg string, fields ...zap.Field)
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import "time"
+
+// Sender is a minimal abstraction over a dropsonde-style metric emitter. It
+// mirrors the shape of dropsonde's MetricSender/MetricBatcher so gorouter
+// can batch counters locally and plug in a real emitter (or none at all) at
+// startup without the callers needing to know the difference.
+type Sender interface {
+	SendCounter(name string, value uint64)
+	SendValue(name string, value float64, unit string)
+	SendDuration(name string, duration time.Duration)
+}
+
+// NullSender is a Sender that discards everything sent to it. It is the
+// default used whenever gorouter has not been configured with a real
+// metric emitter.
+type NullSender struct{}
+
+func (NullSender) SendCounter(name string, value uint64)             {}
+func (NullSender) SendValue(name string, value float64, unit string) {}
+func (NullSender) SendDuration(name string, duration time.Duration)  {}
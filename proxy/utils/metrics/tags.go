@@ -0,0 +1,14 @@
+package metrics
+
+// Tags carries the request-level dimensions that are attached to every
+// metric emitted for a single request/response cycle.
+type Tags struct {
+	// RoutePoolName is the name of the route pool the request was routed to.
+	RoutePoolName string
+	// RouteServiceHit is true when the request was forwarded through a
+	// route service rather than going straight to a backend.
+	RouteServiceHit bool
+	// Hijacked is true when the connection was hijacked out of net/http,
+	// e.g. to service a websocket upgrade.
+	Hijacked bool
+}
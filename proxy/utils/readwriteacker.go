@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// hijackedReadWriteAcker bridges a hijacked connection to a raw byte
+// stream, writing a handshake response line the first time AckConnection
+// is called.
+type hijackedReadWriteAcker struct {
+	conn    net.Conn
+	rw      *bufio.ReadWriter
+	ackLine string
+	acked   bool
+
+	// writeMu serializes AckConnection's handshake write against concurrent
+	// Writes from the tunnel copy loop -- both share rw's underlying
+	// *bufio.Writer, and interleaved writes or flushes would corrupt the
+	// byte stream.
+	writeMu sync.Mutex
+}
+
+// NewReadWriteAcker hijacks w and returns a ReadWriteAcker bridged to the
+// resulting connection. ackLine is the raw HTTP response line (and any
+// headers) to write on the first call to AckConnection, e.g.
+// "HTTP/1.1 101 Switching Protocols\r\n\r\n" or
+// "HTTP/1.1 200 Connection Established\r\n\r\n".
+func NewReadWriteAcker(w ProxyResponseWriter, ackLine string) (*hijackedReadWriteAcker, error) {
+	conn, rw, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	return &hijackedReadWriteAcker{conn: conn, rw: rw, ackLine: ackLine}, nil
+}
+
+// AckConnection writes the handshake response line to the hijacked
+// connection. It is a no-op after the first call.
+func (h *hijackedReadWriteAcker) AckConnection() error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	if h.acked {
+		return nil
+	}
+	h.acked = true
+
+	if _, err := h.rw.WriteString(h.ackLine); err != nil {
+		return err
+	}
+	return h.rw.Flush()
+}
+
+func (h *hijackedReadWriteAcker) Read(p []byte) (int, error) {
+	return h.rw.Read(p)
+}
+
+func (h *hijackedReadWriteAcker) Write(p []byte) (int, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	n, err := h.rw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, h.rw.Flush()
+}
+
+// Close releases the underlying hijacked connection.
+func (h *hijackedReadWriteAcker) Close() error {
+	return h.conn.Close()
+}
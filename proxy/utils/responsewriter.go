@@ -3,10 +3,11 @@ package utils
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
-	"runtime/debug"
+	"time"
+
+	"code.cloudfoundry.org/gorouter/proxy/utils/metrics"
 )
 
 type ProxyResponseWriter interface {
@@ -19,6 +20,12 @@ type ProxyResponseWriter interface {
 	Status() int
 	Size() int
 	CloseNotify() <-chan bool
+	// SetRoutePoolName tags the metrics emitted for this request/response
+	// cycle with the name of the route pool it was routed to.
+	SetRoutePoolName(name string)
+	// SetRouteServiceHit tags the metrics emitted for this request/response
+	// cycle with whether the request was forwarded through a route service.
+	SetRouteServiceHit(hit bool)
 }
 
 type proxyResponseWriter struct {
@@ -28,12 +35,32 @@ type proxyResponseWriter struct {
 
 	flusher http.Flusher
 	done    bool
+
+	sender  metrics.Sender
+	tags    metrics.Tags
+	started time.Time
+
+	firstByteSent   bool
+	timeToFirstByte time.Duration
 }
 
-func NewProxyResponseWriter(w http.ResponseWriter) *proxyResponseWriter {
+// NewProxyResponseWriter wraps w with an instrumented ProxyResponseWriter.
+// sender is used to emit status class counters, bytes written, time to
+// first byte and total request duration; pass metrics.NullSender{} if no
+// emitter is configured. tags carries the request-level dimensions (route
+// pool, route service hit, websocket hijack) that are attached to those
+// metrics.
+func NewProxyResponseWriter(w http.ResponseWriter, sender metrics.Sender, tags metrics.Tags) *proxyResponseWriter {
+	if sender == nil {
+		sender = metrics.NullSender{}
+	}
+
 	proxyWriter := &proxyResponseWriter{
 		w:       w,
 		flusher: w.(http.Flusher),
+		sender:  sender,
+		tags:    tags,
+		started: time.Now(),
 	}
 
 	return proxyWriter
@@ -55,6 +82,7 @@ func (p *proxyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if !ok {
 		return nil, nil, errors.New("response writer cannot hijack")
 	}
+	p.tags.Hijacked = true
 	return hijacker.Hijack()
 }
 
@@ -64,17 +92,15 @@ func (p *proxyResponseWriter) Write(b []byte) (int, error) {
 	}
 
 	if p.status == 0 {
-		fmt.Println("proxy-response-writer-in-WRITE", p.status)
 		p.WriteHeader(http.StatusOK)
 	}
+	p.recordFirstByte()
 	size, err := p.w.Write(b)
 	p.size += size
 	return size, err
 }
 
 func (p *proxyResponseWriter) WriteHeader(s int) {
-	fmt.Println("proxy-response-writer-writing-status-code", s)
-	debug.PrintStack()
 	if p.done {
 		return
 	}
@@ -84,6 +110,7 @@ func (p *proxyResponseWriter) WriteHeader(s int) {
 		p.w.Header()["Content-Type"] = nil
 	}
 
+	p.recordFirstByte()
 	p.w.WriteHeader(s)
 
 	if p.status == 0 {
@@ -91,8 +118,58 @@ func (p *proxyResponseWriter) WriteHeader(s int) {
 	}
 }
 
+// recordFirstByte captures the time-to-first-byte on the first call to
+// Write or WriteHeader, whichever happens first.
+func (p *proxyResponseWriter) recordFirstByte() {
+	if p.firstByteSent {
+		return
+	}
+	p.firstByteSent = true
+	p.timeToFirstByte = time.Since(p.started)
+}
+
 func (p *proxyResponseWriter) Done() {
+	if p.done {
+		return
+	}
 	p.done = true
+	p.emitMetrics(time.Since(p.started))
+}
+
+// emitMetrics pushes the batch of metrics collected for this
+// request/response cycle to the configured Sender.
+func (p *proxyResponseWriter) emitMetrics(duration time.Duration) {
+	p.sender.SendCounter(statusClassMetricName(p.status), 1)
+	p.sender.SendValue("responses.bytes_written", float64(p.size), "B")
+	p.sender.SendDuration("responses.time_to_first_byte", p.timeToFirstByte)
+	p.sender.SendDuration("responses.duration", duration)
+
+	if p.tags.RouteServiceHit {
+		p.sender.SendCounter("responses.route_service", 1)
+	}
+	if p.tags.Hijacked {
+		p.sender.SendCounter("responses.hijacked", 1)
+	}
+	if p.tags.RoutePoolName != "" {
+		p.sender.SendCounter("responses.route_pool."+p.tags.RoutePoolName, 1)
+	}
+}
+
+// statusClassMetricName maps a response status code to the dropsonde-style
+// status class counter name (2xx/3xx/4xx/5xx).
+func statusClassMetricName(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return "responses.2xx"
+	case status >= 300 && status < 400:
+		return "responses.3xx"
+	case status >= 400 && status < 500:
+		return "responses.4xx"
+	case status >= 500 && status < 600:
+		return "responses.5xx"
+	default:
+		return "responses.unknown"
+	}
 }
 
 func (p *proxyResponseWriter) Flush() {
@@ -108,3 +185,11 @@ func (p *proxyResponseWriter) Status() int {
 func (p *proxyResponseWriter) Size() int {
 	return p.size
 }
+
+func (p *proxyResponseWriter) SetRoutePoolName(name string) {
+	p.tags.RoutePoolName = name
+}
+
+func (p *proxyResponseWriter) SetRouteServiceHit(hit bool) {
+	p.tags.RouteServiceHit = hit
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/gorouter/route"
+)
+
+//go:generate counterfeiter -o fakes/fake_registry.go . Registry
+type Registry interface {
+	// Lookup returns the Pool registered for uri, or nil if none is
+	// registered.
+	Lookup(uri route.Uri) *route.Pool
+
+	// RegisterRouteService attaches a route service to host at location (a
+	// URL path prefix; "" for the whole host), optionally rewriting the
+	// Host header on requests forwarded to it.
+	RegisterRouteService(host route.Uri, location string, rsURL string, rewriteHost bool)
+
+	// UnregisterRouteService removes the route service registered for host
+	// at location.
+	UnregisterRouteService(host route.Uri, location string)
+}
+
+// routingTable is the in-memory Registry gorouter uses to track registered
+// routes and the route services attached to them.
+type routingTable struct {
+	mu    sync.RWMutex
+	pools map[route.Uri]*route.Pool
+}
+
+// NewRouteRegistry creates an empty Registry.
+func NewRouteRegistry() Registry {
+	return &routingTable{pools: make(map[route.Uri]*route.Pool)}
+}
+
+func (t *routingTable) Lookup(uri route.Uri) *route.Pool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.pools[uri]
+}
+
+func (t *routingTable) RegisterRouteService(host route.Uri, location string, rsURL string, rewriteHost bool) {
+	t.poolFor(host).RegisterRouteService(location, rsURL, rewriteHost)
+}
+
+func (t *routingTable) UnregisterRouteService(host route.Uri, location string) {
+	t.mu.RLock()
+	pool := t.pools[host]
+	t.mu.RUnlock()
+
+	if pool == nil {
+		return
+	}
+	pool.UnregisterRouteService(location)
+}
+
+func (t *routingTable) poolFor(host route.Uri) *route.Pool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pool, ok := t.pools[host]
+	if !ok {
+		pool = route.NewPool(string(host))
+		t.pools[host] = pool
+	}
+	return pool
+}
@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/gorouter/route"
+)
+
+func TestRegisterRouteServiceCreatesPoolOnDemand(t *testing.T) {
+	r := NewRouteRegistry()
+
+	r.RegisterRouteService("myapp.example.com", "/api", "https://rs-api.example.com", false)
+
+	pool := r.Lookup("myapp.example.com")
+	if pool == nil {
+		t.Fatal("expected a pool to be created for the host")
+	}
+
+	match := pool.MatchRouteService("/api/widgets")
+	if match == nil || match.URL != "https://rs-api.example.com" {
+		t.Fatalf("expected registered route service to match, got %+v", match)
+	}
+}
+
+func TestUnregisterRouteServiceRemovesMatch(t *testing.T) {
+	r := NewRouteRegistry()
+	r.RegisterRouteService("myapp.example.com", "/api", "https://rs-api.example.com", false)
+
+	r.UnregisterRouteService("myapp.example.com", "/api")
+
+	pool := r.Lookup("myapp.example.com")
+	if pool == nil {
+		t.Fatal("expected the pool to still exist")
+	}
+	if match := pool.MatchRouteService("/api/widgets"); match != nil {
+		t.Fatalf("expected no match after unregister, got %+v", match)
+	}
+}
+
+func TestLookupOfUnknownHostReturnsNil(t *testing.T) {
+	r := NewRouteRegistry()
+	if pool := r.Lookup(route.Uri("unknown.example.com")); pool != nil {
+		t.Fatalf("expected nil pool for unknown host, got %+v", pool)
+	}
+}
@@ -0,0 +1,123 @@
+package route
+
+import (
+	"strings"
+	"sync"
+)
+
+// Uri identifies a route's host (and optional path) as registered with
+// gorouter, e.g. "myapp.example.com" or "myapp.example.com/api".
+type Uri string
+
+// Endpoint is a single backend instance registered in a Pool.
+type Endpoint struct {
+	Addr string
+}
+
+// RouteServiceMatch is the route service registration that matched a
+// request path among a Pool's possibly several path-prefixed route
+// service registrations.
+type RouteServiceMatch struct {
+	// URL is the route service endpoint the request should be forwarded to.
+	URL string
+	// Location is the path prefix ("" for the whole host) that was
+	// registered for URL.
+	Location string
+	// RewriteHost indicates the Host header should be rewritten to the
+	// route service's own host before forwarding, to avoid vhost
+	// mismatches at the route service origin.
+	RewriteHost bool
+}
+
+type routeServiceRegistration struct {
+	url         string
+	rewriteHost bool
+}
+
+// Pool groups the backend endpoints registered for a single route, along
+// with any route services registered against it.
+type Pool struct {
+	name string
+
+	mu            sync.RWMutex
+	endpoints     []*Endpoint
+	routeServices map[string]routeServiceRegistration
+}
+
+// NewPool creates an empty Pool for the route named name, e.g. its host.
+func NewPool(name string) *Pool {
+	return &Pool{
+		name:          name,
+		routeServices: make(map[string]routeServiceRegistration),
+	}
+}
+
+// Name returns the name the pool was created with, e.g. for tagging
+// metrics with the route a request was matched to.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Add registers a backend endpoint with the pool.
+func (p *Pool) Add(e *Endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints = append(p.endpoints, e)
+}
+
+// IsEmpty reports whether the pool has no backend endpoints.
+func (p *Pool) IsEmpty() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.endpoints) == 0
+}
+
+// RegisterRouteService attaches a route service to this pool at location
+// (a URL path prefix; "" matches the whole host), optionally rewriting the
+// Host header on requests forwarded to it.
+func (p *Pool) RegisterRouteService(location, rsURL string, rewriteHost bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.routeServices[location] = routeServiceRegistration{url: rsURL, rewriteHost: rewriteHost}
+}
+
+// UnregisterRouteService removes the route service registered at location.
+func (p *Pool) UnregisterRouteService(location string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.routeServices, location)
+}
+
+// MatchRouteService returns the route service registered for the longest
+// matching path prefix of path. An exact location match always wins,
+// since it is necessarily the longest possible match; among the
+// remaining prefix matches, the longest prefix wins. It returns nil when
+// no route service is registered for path.
+func (p *Pool) MatchRouteService(path string) *RouteServiceMatch {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if reg, ok := p.routeServices[path]; ok {
+		return &RouteServiceMatch{URL: reg.url, Location: path, RewriteHost: reg.rewriteHost}
+	}
+
+	var bestLocation string
+	var bestReg routeServiceRegistration
+	found := false
+
+	for location, reg := range p.routeServices {
+		if location != "" && !strings.HasPrefix(path, location) {
+			continue
+		}
+		if !found || len(location) > len(bestLocation) {
+			bestLocation = location
+			bestReg = reg
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return &RouteServiceMatch{URL: bestReg.url, Location: bestLocation, RewriteHost: bestReg.rewriteHost}
+}
@@ -0,0 +1,71 @@
+package route
+
+import "testing"
+
+func TestPoolName(t *testing.T) {
+	p := NewPool("myapp.example.com")
+	if p.Name() != "myapp.example.com" {
+		t.Fatalf("expected pool name %q, got %q", "myapp.example.com", p.Name())
+	}
+}
+
+func TestMatchRouteServiceLongestPrefixWins(t *testing.T) {
+	p := NewPool("test-pool")
+	p.RegisterRouteService("/api", "https://rs-api.example.com", false)
+	p.RegisterRouteService("/api/v2", "https://rs-api-v2.example.com", true)
+
+	match := p.MatchRouteService("/api/v2/widgets")
+	if match == nil {
+		t.Fatal("expected a match")
+	}
+	if match.Location != "/api/v2" {
+		t.Fatalf("expected longest prefix /api/v2 to win, got %q", match.Location)
+	}
+	if match.URL != "https://rs-api-v2.example.com" {
+		t.Fatalf("unexpected URL: %q", match.URL)
+	}
+	if !match.RewriteHost {
+		t.Fatal("expected RewriteHost to be true for the matched registration")
+	}
+}
+
+func TestMatchRouteServiceExactMatchWins(t *testing.T) {
+	p := NewPool("test-pool")
+	p.RegisterRouteService("", "https://rs-default.example.com", false)
+	p.RegisterRouteService("/health", "https://rs-health.example.com", false)
+
+	match := p.MatchRouteService("/health")
+	if match == nil || match.Location != "/health" {
+		t.Fatalf("expected exact match at /health, got %+v", match)
+	}
+}
+
+func TestMatchRouteServiceFallsBackToHostWideRegistration(t *testing.T) {
+	p := NewPool("test-pool")
+	p.RegisterRouteService("", "https://rs-default.example.com", false)
+	p.RegisterRouteService("/api", "https://rs-api.example.com", false)
+
+	match := p.MatchRouteService("/other")
+	if match == nil || match.Location != "" {
+		t.Fatalf("expected the host-wide registration to match, got %+v", match)
+	}
+}
+
+func TestMatchRouteServiceNoMatch(t *testing.T) {
+	p := NewPool("test-pool")
+	p.RegisterRouteService("/api", "https://rs-api.example.com", false)
+
+	if match := p.MatchRouteService("/other"); match != nil {
+		t.Fatalf("expected no match, got %+v", match)
+	}
+}
+
+func TestUnregisterRouteService(t *testing.T) {
+	p := NewPool("test-pool")
+	p.RegisterRouteService("/api", "https://rs-api.example.com", false)
+	p.UnregisterRouteService("/api")
+
+	if match := p.MatchRouteService("/api/widgets"); match != nil {
+		t.Fatalf("expected no match after unregister, got %+v", match)
+	}
+}
@@ -0,0 +1,214 @@
+package routeservice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// RouteServiceSignature carries the base64-encoded HMAC-SHA256 signature
+	// of the RouteServiceMetadata value, which proves a request coming back
+	// from a route service was originally signed by gorouter.
+	RouteServiceSignature = "X-Cf-Proxy-Signature"
+	// RouteServiceMetadata carries the metadata RouteServiceSignature
+	// signs, base64-encoded.
+	RouteServiceMetadata = "X-Cf-Proxy-Metadata"
+	// RouteServiceForwardedURL is the original, pre-route-service URL the
+	// request was destined for.
+	RouteServiceForwardedURL = "X-Cf-Forwarded-Url"
+)
+
+// RouteServiceRequest carries everything ServeHTTP needs to forward a
+// request to a route service: the signed headers to attach, and the
+// parsed route service URL.
+type RouteServiceRequest struct {
+	URLString    string
+	ParsedUrl    *url.URL
+	Signature    string
+	Metadata     string
+	ForwardedURL string
+}
+
+// RouteServiceConfig holds the operator-tunable route service behavior:
+// whether route services are supported at all, the secret used to sign
+// and validate route service requests, and the load balancer probe
+// signature that should bypass route service handling entirely.
+type RouteServiceConfig struct {
+	enabled        bool
+	recommendHttps bool
+	secret         []byte
+
+	// LBProbeUserAgentPrefix, when non-empty, short-circuits any request
+	// whose User-Agent starts with it -- e.g. a cloud load balancer's
+	// health check -- before any route service or backend work is done.
+	LBProbeUserAgentPrefix string
+	// LBProbePath, when non-empty, short-circuits any request for this
+	// exact path the same way LBProbeUserAgentPrefix does.
+	LBProbePath string
+	// LogLBProbeRequests controls whether a matched LB probe still emits
+	// an access log entry. Defaults to false -- probes are frequent and
+	// not interesting to log.
+	LogLBProbeRequests bool
+	// LBProbeResponse is the body written for a matched LB probe. Defaults
+	// to "ok" when empty.
+	LBProbeResponse string
+
+	// ProxyURL is the upstream forward proxy route-service-bound requests
+	// should be tunneled through, or nil to dial route services directly.
+	// It is resolved from ROUTE_SERVICE_HTTP_PROXY / HTTPS_PROXY.
+	ProxyURL *url.URL
+}
+
+// NewRouteServiceConfig creates a RouteServiceConfig. secret is used to
+// sign and validate the X-Cf-Proxy-Signature header. ProxyURL is resolved
+// from the environment via ProxyURLFromEnv.
+func NewRouteServiceConfig(enabled, recommendHttps bool, secret []byte) (*RouteServiceConfig, error) {
+	proxyURL, err := ProxyURLFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RouteServiceConfig{
+		enabled:        enabled,
+		recommendHttps: recommendHttps,
+		secret:         secret,
+		ProxyURL:       proxyURL,
+	}, nil
+}
+
+// RoundTripper returns the http.RoundTripper gorouter should use for
+// route-service-bound requests, tunneling through ProxyURL via an HTTP
+// CONNECT (or absolute-form request) when one is configured, and using
+// transport directly otherwise.
+func (c *RouteServiceConfig) RoundTripper(transport *http.Transport) http.RoundTripper {
+	return NewRouteServiceRoundTripper(transport, c.ProxyURL)
+}
+
+// RouteServiceEnabled reports whether gorouter is configured to support
+// route services at all.
+func (c *RouteServiceConfig) RouteServiceEnabled() bool {
+	return c.enabled
+}
+
+// RouteServiceRecommendHttps reports whether the forwarded URL built for a
+// route service should recommend the https scheme.
+func (c *RouteServiceConfig) RouteServiceRecommendHttps() bool {
+	return c.recommendHttps
+}
+
+// routeServiceMetadataMaxAge bounds how old a signed request's issued_at
+// may be before ValidateSignature rejects it as a replay.
+const routeServiceMetadataMaxAge = 5 * time.Minute
+
+// routeServiceMetadata is the JSON shape signed and carried, base64-encoded,
+// in the RouteServiceMetadata header.
+type routeServiceMetadata struct {
+	ForwardedURL string `json:"forwarded_url"`
+	IssuedAt     string `json:"issued_at"`
+}
+
+// Request builds the signed headers for a fresh request to the route
+// service at rsURL, destined to eventually reach forwardedURLRaw.
+func (c *RouteServiceConfig) Request(rsURL, forwardedURLRaw string) (RouteServiceRequest, error) {
+	parsed, err := url.Parse(rsURL)
+	if err != nil {
+		return RouteServiceRequest{}, err
+	}
+
+	metadataJSON, err := json.Marshal(routeServiceMetadata{
+		ForwardedURL: forwardedURLRaw,
+		IssuedAt:     time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return RouteServiceRequest{}, err
+	}
+	metadata := base64.StdEncoding.EncodeToString(metadataJSON)
+
+	return RouteServiceRequest{
+		URLString:    rsURL,
+		ParsedUrl:    parsed,
+		Signature:    c.sign(metadata),
+		Metadata:     metadata,
+		ForwardedURL: forwardedURLRaw,
+	}, nil
+}
+
+// ValidateSignature checks that header carries a route service signature
+// produced by this config's secret, that the signed metadata's
+// forwarded_url matches forwardedURLRaw -- so a signed request can't be
+// replayed against a different host or path -- and that it was issued
+// within routeServiceMetadataMaxAge.
+func (c *RouteServiceConfig) ValidateSignature(header *http.Header, forwardedURLRaw string) error {
+	signature := header.Get(RouteServiceSignature)
+	metadata := header.Get(RouteServiceMetadata)
+	if signature == "" || metadata == "" {
+		return errors.New("route service signature missing")
+	}
+	if !hmac.Equal([]byte(signature), []byte(c.sign(metadata))) {
+		return errors.New("route service signature mismatch")
+	}
+
+	metadataJSON, err := base64.StdEncoding.DecodeString(metadata)
+	if err != nil {
+		return errors.New("route service metadata malformed")
+	}
+
+	var decoded routeServiceMetadata
+	if err := json.Unmarshal(metadataJSON, &decoded); err != nil {
+		return errors.New("route service metadata malformed")
+	}
+
+	if decoded.ForwardedURL != forwardedURLRaw {
+		return errors.New("route service metadata does not match forwarded url")
+	}
+
+	issuedAt, err := time.Parse(time.RFC3339, decoded.IssuedAt)
+	if err != nil {
+		return errors.New("route service metadata issued_at malformed")
+	}
+	if time.Since(issuedAt) > routeServiceMetadataMaxAge {
+		return errors.New("route service signature expired")
+	}
+
+	return nil
+}
+
+func (c *RouteServiceConfig) sign(metadata string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(metadata))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IsLBProbe reports whether req matches the configured load balancer probe
+// signature -- a User-Agent prefix, an exact path, or both -- so it can be
+// answered immediately without any route service or backend work.
+func (c *RouteServiceConfig) IsLBProbe(req *http.Request) bool {
+	if c.LBProbeUserAgentPrefix != "" && strings.HasPrefix(req.UserAgent(), c.LBProbeUserAgentPrefix) {
+		return true
+	}
+	if c.LBProbePath != "" && req.URL.Path == c.LBProbePath {
+		return true
+	}
+	return false
+}
+
+// LogLBProbes reports whether a matched LB probe should still emit an
+// access log entry.
+func (c *RouteServiceConfig) LogLBProbes() bool {
+	return c.LogLBProbeRequests
+}
+
+// LBProbeResponseBody is the body written for a matched LB probe.
+func (c *RouteServiceConfig) LBProbeResponseBody() string {
+	if c.LBProbeResponse == "" {
+		return "ok"
+	}
+	return c.LBProbeResponse
+}
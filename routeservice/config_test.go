@@ -0,0 +1,120 @@
+package routeservice
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsLBProbeMatchesUserAgentPrefix(t *testing.T) {
+	c := &RouteServiceConfig{LBProbeUserAgentPrefix: "Mozilla/5.0 (compatible; CF-Traffic-Manager"}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; CF-Traffic-Manager/1.0)")
+
+	if !c.IsLBProbe(req) {
+		t.Fatal("expected request with matching User-Agent prefix to be detected as an LB probe")
+	}
+}
+
+func TestIsLBProbeMatchesPath(t *testing.T) {
+	c := &RouteServiceConfig{LBProbePath: "/healthz"}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	if !c.IsLBProbe(req) {
+		t.Fatal("expected request with matching path to be detected as an LB probe")
+	}
+
+	req = httptest.NewRequest("GET", "/other", nil)
+	if c.IsLBProbe(req) {
+		t.Fatal("expected request with non-matching path not to be detected as an LB probe")
+	}
+}
+
+func TestLBProbeResponseBodyDefaultsToOk(t *testing.T) {
+	c := &RouteServiceConfig{}
+	if c.LBProbeResponseBody() != "ok" {
+		t.Fatalf("expected default LB probe body %q, got %q", "ok", c.LBProbeResponseBody())
+	}
+
+	c.LBProbeResponse = "healthy"
+	if c.LBProbeResponseBody() != "healthy" {
+		t.Fatalf("expected configured LB probe body %q, got %q", "healthy", c.LBProbeResponseBody())
+	}
+}
+
+func TestValidateSignatureAcceptsFreshSignedRequest(t *testing.T) {
+	c := &RouteServiceConfig{secret: []byte("shh")}
+	forwardedURL := "https://app.example.com/foo"
+
+	rsReq, err := c.Request("https://rs.example.com", forwardedURL)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set(RouteServiceSignature, rsReq.Signature)
+	header.Set(RouteServiceMetadata, rsReq.Metadata)
+
+	if err := c.ValidateSignature(&header, forwardedURL); err != nil {
+		t.Fatalf("expected a freshly signed request to validate, got %v", err)
+	}
+}
+
+func TestValidateSignatureRejectsReplayAgainstDifferentForwardedURL(t *testing.T) {
+	c := &RouteServiceConfig{secret: []byte("shh")}
+
+	rsReq, err := c.Request("https://rs.example.com", "https://app.example.com/foo")
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set(RouteServiceSignature, rsReq.Signature)
+	header.Set(RouteServiceMetadata, rsReq.Metadata)
+
+	if err := c.ValidateSignature(&header, "https://app.example.com/other-path"); err == nil {
+		t.Fatal("expected replaying a signed request against a different forwarded URL to fail validation")
+	}
+}
+
+func TestValidateSignatureRejectsExpiredMetadata(t *testing.T) {
+	c := &RouteServiceConfig{secret: []byte("shh")}
+	forwardedURL := "https://app.example.com/foo"
+
+	metadataJSON, err := json.Marshal(routeServiceMetadata{
+		ForwardedURL: forwardedURL,
+		IssuedAt:     time.Now().Add(-1 * time.Hour).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("marshal metadata: %v", err)
+	}
+	metadata := base64.StdEncoding.EncodeToString(metadataJSON)
+
+	header := http.Header{}
+	header.Set(RouteServiceSignature, c.sign(metadata))
+	header.Set(RouteServiceMetadata, metadata)
+
+	if err := c.ValidateSignature(&header, forwardedURL); err == nil {
+		t.Fatal("expected metadata older than routeServiceMetadataMaxAge to fail validation")
+	}
+}
+
+func TestRoundTripperUsesConfiguredProxyURL(t *testing.T) {
+	c, err := NewRouteServiceConfig(true, true, []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewRouteServiceConfig: %v", err)
+	}
+
+	got := c.RoundTripper(nil)
+	rt, ok := got.(*RouteServiceRoundTripper)
+	if !ok {
+		t.Fatalf("expected a *RouteServiceRoundTripper, got %T", got)
+	}
+	if rt.ProxyURL != c.ProxyURL {
+		t.Fatal("expected the round tripper to use the config's ProxyURL")
+	}
+}
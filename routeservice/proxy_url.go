@@ -0,0 +1,25 @@
+package routeservice
+
+import (
+	"net/url"
+	"os"
+)
+
+// ProxyURLFromEnv resolves the upstream forward proxy that route service
+// egress should be sent through, so gorouter can be deployed in
+// network-restricted environments where external route services are only
+// reachable via a corporate proxy. ROUTE_SERVICE_HTTP_PROXY takes
+// precedence over HTTPS_PROXY, so operators can scope the override to
+// route services without affecting other outbound traffic. It returns a
+// nil URL, nil error when neither variable is set.
+func ProxyURLFromEnv() (*url.URL, error) {
+	raw := os.Getenv("ROUTE_SERVICE_HTTP_PROXY")
+	if raw == "" {
+		raw = os.Getenv("HTTPS_PROXY")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	return url.Parse(raw)
+}
@@ -0,0 +1,198 @@
+package routeservice
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RouteServiceRoundTripper is an http.RoundTripper for route-service-bound
+// requests. With ProxyURL unset it simply delegates to Transport. With
+// ProxyURL set it tunnels the request through that upstream forward proxy
+// instead: an HTTP CONNECT handshake followed by a TLS handshake for
+// https:// route services, or an absolute-form request for plaintext ones
+// -- the same approach Kubernetes' SpdyRoundTripper uses to reach a proxy
+// that fronts a TLS endpoint.
+type RouteServiceRoundTripper struct {
+	// Transport performs the round trip directly when ProxyURL is nil.
+	// Its TLSClientConfig is reused for the TLS handshake with the
+	// route service when tunneling through ProxyURL.
+	Transport *http.Transport
+	// ProxyURL is the upstream forward proxy to dial, or nil to disable
+	// proxying.
+	ProxyURL *url.URL
+	// DialTimeout bounds dialing the proxy and completing the CONNECT
+	// handshake.
+	DialTimeout time.Duration
+}
+
+// NewRouteServiceRoundTripper builds a RouteServiceRoundTripper that sends
+// route-service-bound requests through proxyURL (nil for none), using
+// transport for TLS configuration and for direct round trips.
+func NewRouteServiceRoundTripper(transport *http.Transport, proxyURL *url.URL) *RouteServiceRoundTripper {
+	return &RouteServiceRoundTripper{
+		Transport:   transport,
+		ProxyURL:    proxyURL,
+		DialTimeout: 10 * time.Second,
+	}
+}
+
+func (rt *RouteServiceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.ProxyURL == nil {
+		return rt.Transport.RoundTrip(req)
+	}
+
+	if req.URL.Scheme == "https" {
+		return rt.roundTripThroughConnectTunnel(req)
+	}
+
+	return rt.roundTripThroughAbsoluteForm(req)
+}
+
+// roundTripThroughConnectTunnel issues a CONNECT to ProxyURL, upgrades the
+// resulting connection to TLS, then writes req over it directly.
+func (rt *RouteServiceRoundTripper) roundTripThroughConnectTunnel(req *http.Request) (*http.Response, error) {
+	conn, err := rt.dialProxy()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rt.sendConnect(conn, canonicalAddr(req.URL)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if rt.Transport != nil && rt.Transport.TLSClientConfig != nil {
+		tlsConfig = rt.Transport.TLSClientConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = req.URL.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := req.Write(tlsConn); err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), req)
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	resp.Body = &connCloseBody{ReadCloser: resp.Body, conn: tlsConn}
+	return resp, nil
+}
+
+// roundTripThroughAbsoluteForm sends req to ProxyURL as a plaintext,
+// absolute-form request, the way a browser talks to an HTTP forward proxy.
+func (rt *RouteServiceRoundTripper) roundTripThroughAbsoluteForm(req *http.Request) (*http.Response, error) {
+	conn, err := rt.dialProxy()
+	if err != nil {
+		return nil, err
+	}
+
+	absReq := req.Clone(req.Context())
+	absReq.RequestURI = ""
+	rt.setProxyAuth(absReq.Header)
+
+	if err := absReq.WriteProxy(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &connCloseBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// connCloseBody wraps a response body read off a manually dialed conn.
+// http.ReadResponse doesn't take ownership of conn, so without this,
+// resp.Body.Close() would leave it open and leak it.
+type connCloseBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *connCloseBody) Close() error {
+	bodyErr := b.ReadCloser.Close()
+	connErr := b.conn.Close()
+	if bodyErr != nil {
+		return bodyErr
+	}
+	return connErr
+}
+
+func (rt *RouteServiceRoundTripper) dialProxy() (net.Conn, error) {
+	dialer := net.Dialer{Timeout: rt.DialTimeout}
+	return dialer.Dial("tcp", rt.ProxyURL.Host)
+}
+
+// sendConnect performs the "CONNECT host:port HTTP/1.1" handshake against
+// an already-dialed proxy connection and consumes its response, returning
+// an error unless the proxy answered 200.
+func (rt *RouteServiceRoundTripper) sendConnect(conn net.Conn, targetAddr string) error {
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: targetAddr},
+		Host:   targetAddr,
+		Header: make(http.Header),
+	}
+	rt.setProxyAuth(connectReq.Header)
+
+	if err := connectReq.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("route service proxy: CONNECT to %s failed: %s", targetAddr, resp.Status)
+	}
+	return nil
+}
+
+// setProxyAuth attaches Proxy-Authorization when ProxyURL carries Basic
+// auth credentials.
+func (rt *RouteServiceRoundTripper) setProxyAuth(header http.Header) {
+	user := rt.ProxyURL.User
+	if user == nil {
+		return
+	}
+	password, _ := user.Password()
+	creds := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+	header.Set("Proxy-Authorization", "Basic "+creds)
+}
+
+// canonicalAddr returns u's host:port, defaulting the port for the scheme
+// when one isn't present.
+func canonicalAddr(u *url.URL) string {
+	if strings.Contains(u.Host, ":") {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return u.Host + ":443"
+	}
+	return u.Host + ":80"
+}
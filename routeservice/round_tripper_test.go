@@ -0,0 +1,228 @@
+package routeservice
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func fakeProxyListener(t *testing.T) (net.Listener, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	return ln, ln.Addr().String()
+}
+
+func TestSendConnectWritesHandshakeAndHonorsProxyAuth(t *testing.T) {
+	ln, addr := fakeProxyListener(t)
+	defer ln.Close()
+
+	proxyURL, err := url.Parse("http://user:pass@" + addr)
+	if err != nil {
+		t.Fatalf("parse proxy url: %v", err)
+	}
+	rt := NewRouteServiceRoundTripper(&http.Transport{}, proxyURL)
+
+	var gotMethod, gotHost, gotAuth string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		gotMethod = req.Method
+		gotHost = req.Host
+		gotAuth = req.Header.Get("Proxy-Authorization")
+
+		io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	}()
+
+	clientConn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	if err := rt.sendConnect(clientConn, "backend.example.com:443"); err != nil {
+		t.Fatalf("sendConnect: %v", err)
+	}
+	<-done
+
+	if gotMethod != http.MethodConnect {
+		t.Fatalf("expected CONNECT, got %q", gotMethod)
+	}
+	if gotHost != "backend.example.com:443" {
+		t.Fatalf("expected CONNECT target backend.example.com:443, got %q", gotHost)
+	}
+	if gotAuth == "" {
+		t.Fatal("expected a Proxy-Authorization header to be sent for a proxy URL with credentials")
+	}
+}
+
+func TestSendConnectFailsOnNonOKStatus(t *testing.T) {
+	ln, addr := fakeProxyListener(t)
+	defer ln.Close()
+
+	proxyURL, err := url.Parse("http://" + addr)
+	if err != nil {
+		t.Fatalf("parse proxy url: %v", err)
+	}
+	rt := NewRouteServiceRoundTripper(&http.Transport{}, proxyURL)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+	}()
+
+	clientConn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	if err := rt.sendConnect(clientConn, "backend.example.com:443"); err == nil {
+		t.Fatal("expected an error for a non-200 CONNECT response")
+	}
+}
+
+func TestRoundTripThroughAbsoluteFormProxy(t *testing.T) {
+	ln, addr := fakeProxyListener(t)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if req.URL.Scheme != "http" || req.URL.Host != "backend.example.com" {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	}()
+
+	proxyURL, err := url.Parse("http://" + addr)
+	if err != nil {
+		t.Fatalf("parse proxy url: %v", err)
+	}
+	rt := NewRouteServiceRoundTripper(&http.Transport{}, proxyURL)
+
+	req, err := http.NewRequest(http.MethodGet, "http://backend.example.com/path", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestRoundTripThroughAbsoluteFormClosesUnderlyingConnOnBodyClose(t *testing.T) {
+	ln, addr := fakeProxyListener(t)
+	defer ln.Close()
+
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		connCh <- conn
+
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	}()
+
+	proxyURL, err := url.Parse("http://" + addr)
+	if err != nil {
+		t.Fatalf("parse proxy url: %v", err)
+	}
+	rt := NewRouteServiceRoundTripper(&http.Transport{}, proxyURL)
+
+	req, err := http.NewRequest(http.MethodGet, "http://backend.example.com/path", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	var serverConn net.Conn
+	select {
+	case serverConn = <-connCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy never accepted a connection")
+	}
+	defer serverConn.Close()
+
+	if err := resp.Body.Close(); err != nil {
+		t.Fatalf("closing response body: %v", err)
+	}
+
+	serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1)
+	if _, err := serverConn.Read(buf); err != io.EOF {
+		t.Fatalf("expected the proxy side to see EOF once the response body is closed, got %v", err)
+	}
+}
+
+func TestRoundTripWithoutProxyURLDelegatesToTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+
+	rt := NewRouteServiceRoundTripper(&http.Transport{}, nil)
+	if rt.ProxyURL != nil {
+		t.Fatal("expected a nil ProxyURL to disable proxying")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTeapot {
+		t.Fatalf("expected the backend's 418 via the direct transport, got %d", resp.StatusCode)
+	}
+}